@@ -0,0 +1,86 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newLetsEncryptManager builds the autocert manager responsible for obtaining and
+// renewing certificates for the whitelisted hosts
+func newLetsEncryptManager(cfg *Config) (*autocert.Manager, error) {
+	if len(cfg.LetsEncryptHosts) <= 0 {
+		return nil, errors.New("no letsencrypt-hosts have been whitelisted")
+	}
+	cacheDir := defaultTo(cfg.LetsEncryptCacheDir, "./cache/")
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.LetsEncryptHosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      cfg.LetsEncryptEmail,
+	}
+	if cfg.LetsEncryptDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: cfg.LetsEncryptDirectoryURL}
+	}
+
+	return manager, nil
+}
+
+// newLetsEncryptTLSConfig returns the tls configuration the main listener should use
+// in order to terminate connections with a certificate provisioned by the manager
+func newLetsEncryptTLSConfig(manager *autocert.Manager) *tls.Config {
+	return manager.TLSConfig()
+}
+
+// createHTTPChallengeServer builds the plain HTTP listener which serves ACME HTTP-01
+// challenges and redirects everything else to the https equivalent of the request
+func createHTTPChallengeServer(cfg *Config, manager *autocert.Manager) *http.Server {
+	listen := defaultTo(cfg.LetsEncryptHTTPListen, ":80")
+
+	return &http.Server{
+		Addr:    listen,
+		Handler: manager.HTTPHandler(http.HandlerFunc(redirectToHTTPS)),
+	}
+}
+
+// redirectToHTTPS issues a permanent redirect to the https version of the request,
+// used as the fallback handler for anything which isn't an ACME challenge
+func redirectToHTTPS(w http.ResponseWriter, req *http.Request) {
+	target := "https://" + req.Host + req.URL.RequestURI()
+	http.Redirect(w, req, target, http.StatusMovedPermanently)
+}
+
+// startLetsEncryptChallengeServer starts the HTTP-01 challenge listener in the background,
+// logging (rather than failing the main listener) should it be unable to bind.
+// http.ErrServerClosed is the expected result of a clean shutdown, not a failure, so
+// it's excluded from that logging
+func startLetsEncryptChallengeServer(cfg *Config, manager *autocert.Manager) {
+	server := createHTTPChallengeServer(cfg, manager)
+
+	go func() {
+		log.Infof("starting the letsencrypt http challenge listener on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("letsencrypt http challenge listener exited, error: %s", err)
+		}
+	}()
+}
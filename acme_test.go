@@ -0,0 +1,109 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestNewLetsEncryptManagerRequiresHosts(t *testing.T) {
+	if _, err := newLetsEncryptManager(&Config{}); err == nil {
+		t.Error("expected an error when no letsencrypt-hosts are whitelisted")
+	}
+}
+
+func TestNewLetsEncryptManagerHostWhitelist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acme-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{
+		LetsEncryptHosts:    []string{"app.example.com", "api.example.com"},
+		LetsEncryptCacheDir: dir,
+		LetsEncryptEmail:    "ops@example.com",
+	}
+	manager, err := newLetsEncryptManager(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cases := []struct {
+		host      string
+		expectErr bool
+	}{
+		{"app.example.com", false},
+		{"api.example.com", false},
+		{"evil.example.com", true},
+	}
+	for _, c := range cases {
+		if err := manager.HostPolicy(context.Background(), c.host); (err != nil) != c.expectErr {
+			t.Errorf("host %q: expected error=%v, got err=%v", c.host, c.expectErr, err)
+		}
+	}
+}
+
+func TestNewLetsEncryptManagerDefaultCacheDir(t *testing.T) {
+	cfg := &Config{LetsEncryptHosts: []string{"app.example.com"}}
+	manager, err := newLetsEncryptManager(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if manager.Cache == nil {
+		t.Fatal("expected a default cache directory to be configured")
+	}
+}
+
+func TestCreateHTTPChallengeServerDefaults(t *testing.T) {
+	cfg := &Config{LetsEncryptHosts: []string{"app.example.com"}}
+	manager, err := newLetsEncryptManager(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	server := createHTTPChallengeServer(cfg, manager)
+	if server.Addr != ":80" {
+		t.Errorf("expected the default challenge listener to bind to :80, got %s", server.Addr)
+	}
+
+	cfg.LetsEncryptHTTPListen = ":8080"
+	server = createHTTPChallengeServer(cfg, manager)
+	if server.Addr != ":8080" {
+		t.Errorf("expected the configured challenge listener address to be honoured, got %s", server.Addr)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com/some/path?x=1", nil)
+	recorder := httptest.NewRecorder()
+
+	redirectToHTTPS(recorder, req)
+
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected a %d response, got %d", http.StatusMovedPermanently, recorder.Code)
+	}
+	location := recorder.Header().Get("Location")
+	expected := "https://app.example.com/some/path?x=1"
+	if location != expected {
+		t.Errorf("expected redirect to %q, got %q", expected, location)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+// newConfigCommand groups the configuration related subcommands under `gatekeeper config`
+func newConfigCommand() cli.Command {
+	return cli.Command{
+		Name:  "config",
+		Usage: "configuration file utilities",
+		Subcommands: []cli.Command{
+			newConfigValidateCommand(),
+		},
+	}
+}
+
+// newConfigValidateCommand returns the `gatekeeper config validate` subcommand, letting
+// CI pipelines lint a configuration file -- including its includes and environment
+// variable interpolation -- without starting the proxy
+func newConfigValidateCommand() cli.Command {
+	return cli.Command{
+		Name:  "validate",
+		Usage: "validate a configuration file without starting the proxy",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "config",
+				Usage: "the path to the configuration file to validate",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			filename := cx.String("config")
+			if filename == "" {
+				return printError("you must specify a configuration file via --config")
+			}
+
+			config := &Config{}
+			if err := readConfigFile(filename, config); err != nil {
+				return printError("%s", err)
+			}
+			fmt.Printf("configuration %s is valid\n", filename)
+
+			return nil
+		},
+	}
+}
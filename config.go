@@ -0,0 +1,113 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// Config is the configuration for the proxy
+type Config struct {
+	// DiscoveryURL is the url for the openid provider configuration
+	DiscoveryURL string `json:"discovery-url" yaml:"discovery-url"`
+	// ClientID is the client id for the openid provider
+	ClientID string `json:"client-id" yaml:"client-id"`
+	// ClientSecret is the secret for the openid provider
+	ClientSecret string `json:"client-secret" yaml:"client-secret"`
+	// RedirectionURL is the url to redirect back to once authenticated
+	RedirectionURL string `json:"redirection-url" yaml:"redirection-url"`
+	// Scopes is a list of additional scopes to request
+	Scopes []string `json:"scopes" yaml:"scopes"`
+	// SkipOpenIDProviderTLSVerify skips the verification of the openid provider's certificate
+	SkipOpenIDProviderTLSVerify bool `json:"skip-openid-provider-tls-verify" yaml:"skip-openid-provider-tls-verify"`
+
+	// Listen is the interface the proxy should bind to
+	Listen string `json:"listen" yaml:"listen"`
+	// TLSCertificate is the path to the certificate used for the main listener
+	TLSCertificate string `json:"tls-cert" yaml:"tls-cert"`
+	// TLSPrivateKey is the path to the private key used for the main listener
+	TLSPrivateKey string `json:"tls-private-key" yaml:"tls-private-key"`
+
+	// ConfigFile is the path the configuration was loaded from, retained so it can be
+	// re-read on a configuration reload
+	ConfigFile string `json:"-" yaml:"-"`
+	// EncryptionKey is used to encrypt and decrypt the session state, acts as sugar for
+	// a single-element EncryptionKeys when the latter is not set
+	EncryptionKey string `json:"encryption-key" yaml:"encryption-key"`
+	// EncryptionKeys is an ordered keyring used to encrypt and decrypt the session state,
+	// the first entry is the active key used to encrypt new sessions, the remainder are
+	// retained as decrypt-only so that rotating in a new key doesn't invalidate existing
+	// cookies
+	EncryptionKeys []string `json:"encryption-keys" yaml:"encryption-keys"`
+
+	// EnableLetsEncrypt switches on automatic certificate provisioning via ACME
+	EnableLetsEncrypt bool `json:"enable-letsencrypt" yaml:"enable-letsencrypt"`
+	// LetsEncryptCacheDir is the directory the provisioned certificates are cached in
+	LetsEncryptCacheDir string `json:"letsencrypt-cache-dir" yaml:"letsencrypt-cache-dir"`
+	// LetsEncryptHosts is the whitelist of hostnames the manager is permitted to provision for
+	LetsEncryptHosts []string `json:"letsencrypt-hosts" yaml:"letsencrypt-hosts"`
+	// LetsEncryptEmail is the contact address registered with the ACME account
+	LetsEncryptEmail string `json:"letsencrypt-email" yaml:"letsencrypt-email"`
+	// LetsEncryptDirectoryURL overrides the default ACME directory, useful for staging or ZeroSSL
+	LetsEncryptDirectoryURL string `json:"letsencrypt-directory-url" yaml:"letsencrypt-directory-url"`
+	// LetsEncryptHTTPListen is the interface the HTTP-01 challenge handler binds to
+	LetsEncryptHTTPListen string `json:"letsencrypt-http-listen" yaml:"letsencrypt-http-listen"`
+
+	// UpstreamCACertificate is a PEM bundle used to validate the upstream's certificate
+	UpstreamCACertificate string `json:"upstream-ca-certificate" yaml:"upstream-ca-certificate"`
+	// UpstreamClientCertificate is the certificate presented to the upstream for mutual TLS
+	UpstreamClientCertificate string `json:"upstream-client-certificate" yaml:"upstream-client-certificate"`
+	// UpstreamClientKey is the private key which pairs with UpstreamClientCertificate
+	UpstreamClientKey string `json:"upstream-client-key" yaml:"upstream-client-key"`
+	// UpstreamTLSServerName overrides the server name used when validating the upstream's certificate
+	UpstreamTLSServerName string `json:"upstream-tls-server-name" yaml:"upstream-tls-server-name"`
+	// UpstreamSkipVerify disables validation of the upstream's certificate, not recommended outside of development
+	UpstreamSkipVerify bool `json:"upstream-skip-verify" yaml:"upstream-skip-verify"`
+	// UpstreamMinTLSVersion is the minimum tls version accepted when connecting to the upstream
+	UpstreamMinTLSVersion string `json:"upstream-min-tls-version" yaml:"upstream-min-tls-version"`
+
+	// LocalAuthFile is an htpasswd file (bcrypt or SHA entries) used as a break-glass
+	// basic-auth fallback for the paths listed in LocalAuthPaths
+	LocalAuthFile string `json:"local-auth-file" yaml:"local-auth-file"`
+	// LocalAuthPaths is the list of path prefixes which may be satisfied by a local
+	// basic-auth login instead of a Keycloak session
+	LocalAuthPaths []string `json:"local-auth-paths" yaml:"local-auth-paths"`
+	// LocalAuthRoles is the set of roles granted to an identity synthesized from a
+	// successful local-auth login
+	LocalAuthRoles []string `json:"local-auth-roles" yaml:"local-auth-roles"`
+	// LocalAuthRealm is the realm advertised in the WWW-Authenticate challenge
+	LocalAuthRealm string `json:"local-auth-realm" yaml:"local-auth-realm"`
+
+	// Roles is the full set of realm roles this deployment knows about. When set, it
+	// is used to catch typos in a Resource's RolesAllowed at startup rather than at
+	// request time; when empty, role references are not cross-checked
+	Roles []string `json:"roles" yaml:"roles"`
+	// Resources is the list of upstream paths protected by the proxy
+	Resources []*Resource `json:"resources" yaml:"resources"`
+}
+
+// Resource describes an upstream path the proxy protects, and the roles a caller
+// must present in their token (or via LocalAuth) in order to reach it
+type Resource struct {
+	// URL is the request path this resource matches, must be unique across all resources
+	URL string `json:"url" yaml:"url"`
+	// Methods restricts the match to a specific set of http methods, empty means all
+	Methods []string `json:"methods" yaml:"methods"`
+	// RolesAllowed is the list of roles permitted to access the resource
+	RolesAllowed []string `json:"roles" yaml:"roles"`
+	// WhiteListed marks the resource as requiring no authentication at all
+	WhiteListed bool `json:"white-listed" yaml:"white-listed"`
+	// LocalAuth permits this specific resource to be satisfied by the local htpasswd
+	// fallback instead of a Keycloak session, as an alternative to listing the path
+	// in the coarser-grained LocalAuthPaths
+	LocalAuth bool `json:"local-auth" yaml:"local-auth"`
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envVarPattern matches ${VAR} and ${VAR:-default} references; a literal $$ in the
+// source file is an escape hatch for values which legitimately contain a dollar sign
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnvVars replaces ${VAR} / ${VAR:-default} references in the raw config bytes
+// with values from the process environment before it's handed to the yaml/json decoder.
+// A ${VAR} reference with no `:-default` fallback whose variable is unset is an error
+// rather than a silent empty string, since that would otherwise blank out values like
+// client-secret or encryption-key at boot without any indication of why
+func expandEnvVars(content []byte) ([]byte, error) {
+	escaped := bytes.Replace(content, []byte("$$"), []byte("\x00"), -1)
+
+	var missing []string
+	expanded := envVarPattern.ReplaceAllFunc(escaped, func(match []byte) []byte {
+		groups := envVarPattern.FindSubmatch(match)
+		name, hasDefault, fallback := string(groups[1]), len(groups[2]) > 0, string(groups[3])
+		if value, found := os.LookupEnv(name); found {
+			return []byte(value)
+		}
+		if hasDefault {
+			return []byte(fallback)
+		}
+
+		missing = append(missing, name)
+		return nil
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the following referenced environment variables are not set and have no default: %s", strings.Join(missing, ", "))
+	}
+
+	return bytes.Replace(expanded, []byte("\x00"), []byte("$"), -1), nil
+}
+
+// decodeDocument parses a single config fragment into a generic map, using the yaml
+// or json decoder depending on the file extension
+func decodeDocument(filename string, content []byte) (map[string]interface{}, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		doc := make(map[string]interface{})
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+
+		return doc, nil
+	}
+
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return normalizeYAMLMap(raw), nil
+}
+
+// normalizeYAMLMap converts the map[interface{}]interface{} produced by yaml.v2 into
+// map[string]interface{} so fragments loaded from yaml and json sources can be merged
+// with the same code path
+func normalizeYAMLMap(in map[interface{}]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		out[fmt.Sprintf("%v", k)] = normalizeYAMLValue(v)
+	}
+
+	return out
+}
+
+func normalizeYAMLValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		return normalizeYAMLMap(value)
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			list[i] = normalizeYAMLValue(item)
+		}
+
+		return list
+	default:
+		return value
+	}
+}
+
+// deepMergeMaps merges src into dst, with src taking precedence; nested maps are
+// merged recursively and lists are concatenated (dst's entries first, then src's) so
+// that, for example, two included fragments each contributing a `resources:` list end
+// up with every resource present rather than the later fragment silently replacing
+// the earlier one. Any other value is simply overwritten
+func deepMergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		if existing, found := dst[k]; found {
+			if existingMap, valueMap, ok := bothMaps(existing, v); ok {
+				dst[k] = deepMergeMaps(existingMap, valueMap)
+				continue
+			}
+			if existingList, valueList, ok := bothLists(existing, v); ok {
+				dst[k] = append(append([]interface{}{}, existingList...), valueList...)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+
+	return dst
+}
+
+func bothMaps(a, b interface{}) (map[string]interface{}, map[string]interface{}, bool) {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+
+	return am, bm, aok && bok
+}
+
+func bothLists(a, b interface{}) ([]interface{}, []interface{}, bool) {
+	al, aok := a.([]interface{})
+	bl, bok := b.([]interface{})
+
+	return al, bl, aok && bok
+}
+
+// loadConfigTree reads filename, expands its environment variable references and
+// recursively merges in any `includes` fragments (later includes override earlier
+// ones, and the including file overrides all of its includes; list values are
+// concatenated in that same order instead, see deepMergeMaps), detecting cycles
+// along the way. `visited` tracks only the files currently on the path from the root
+// to this call, not every file ever loaded, so a fragment legitimately included by
+// two different files (a diamond, e.g. several resource configs sharing a common
+// defaults fragment) is not mistaken for a cycle -- it's unmarked again once this
+// branch of the include tree finishes
+func loadConfigTree(filename string, visited map[string]bool) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if visited[abs] {
+		return nil, fmt.Errorf("include cycle detected at: %s", filename)
+	}
+	visited[abs] = true
+	defer delete(visited, abs)
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	content, err = expandEnvVars(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	doc, err := decodeDocument(filename, content)
+	if err != nil {
+		return nil, err
+	}
+
+	includes, _ := doc["includes"].([]interface{})
+	delete(doc, "includes")
+
+	merged := make(map[string]interface{})
+	for _, include := range includes {
+		path, ok := include.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: includes must be a list of file paths", filename)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(filename), path)
+		}
+
+		fragment, err := loadConfigTree(path, visited)
+		if err != nil {
+			return nil, err
+		}
+		merged = deepMergeMaps(merged, fragment)
+	}
+
+	return deepMergeMaps(merged, doc), nil
+}
+
+// readConfigFile reads and parses the configuration file, expanding ${VAR} and
+// ${VAR:-default} environment references, resolving any `includes` fragments,
+// rejecting unknown keys and running a post-unmarshal validation pass before
+// handing back a usable Config
+func readConfigFile(filename string, config *Config) error {
+	merged, err := loadConfigTree(filename, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	config.ConfigFile = filename
+
+	return validateConfig(config)
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFixture(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unable to write fixture %s: %s", path, err)
+	}
+
+	return path
+}
+
+func TestExpandEnvVars(t *testing.T) {
+	os.Setenv("GATEKEEPER_TEST_VAR", "resolved-value")
+	defer os.Unsetenv("GATEKEEPER_TEST_VAR")
+	os.Unsetenv("GATEKEEPER_TEST_MISSING")
+
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"resolves a set var", "value: ${GATEKEEPER_TEST_VAR}", "value: resolved-value"},
+		{"falls back when unset", "value: ${GATEKEEPER_TEST_MISSING:-fallback}", "value: fallback"},
+		{"set var ignores its own default", "value: ${GATEKEEPER_TEST_VAR:-fallback}", "value: resolved-value"},
+		{"escaped dollar is left alone", "value: $${NOT_A_VAR}", "value: ${NOT_A_VAR}"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := expandEnvVars([]byte(c.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(got) != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, string(got))
+			}
+		})
+	}
+}
+
+// TestExpandEnvVarsRejectsUnsetWithoutDefault guards against a referenced variable
+// silently expanding to an empty string when it has no `:-default` fallback, which
+// would otherwise blank out secrets like client-secret or encryption-key at boot
+func TestExpandEnvVarsRejectsUnsetWithoutDefault(t *testing.T) {
+	os.Unsetenv("GATEKEEPER_TEST_MISSING")
+
+	if _, err := expandEnvVars([]byte("value: ${GATEKEEPER_TEST_MISSING}")); err == nil {
+		t.Error("expected an unset variable with no default to be rejected")
+	}
+}
+
+func TestReadConfigFileEnvInterpolation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-loader-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("GATEKEEPER_TEST_CLIENT_ID", "my-client")
+	defer os.Unsetenv("GATEKEEPER_TEST_CLIENT_ID")
+
+	path := writeConfigFixture(t, dir, "config.yaml", `
+client-id: ${GATEKEEPER_TEST_CLIENT_ID}
+encryption-key: "0123456789012345"
+`)
+
+	cfg := &Config{}
+	if err := readConfigFile(path, cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.ClientID != "my-client" {
+		t.Errorf("expected client-id to be interpolated, got %q", cfg.ClientID)
+	}
+}
+
+func TestReadConfigFileIncludesOverrideOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-loader-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeConfigFixture(t, dir, "base-defaults.yaml", `
+client-id: from-fragment
+client-secret: fragment-secret
+`)
+	path := writeConfigFixture(t, dir, "config.yaml", `
+includes:
+  - base-defaults.yaml
+client-id: from-root
+encryption-key: "0123456789012345"
+`)
+
+	cfg := &Config{}
+	if err := readConfigFile(path, cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.ClientID != "from-root" {
+		t.Errorf("expected the including file to override the fragment, got %q", cfg.ClientID)
+	}
+	if cfg.ClientSecret != "fragment-secret" {
+		t.Errorf("expected the value only set in the fragment to be merged in, got %q", cfg.ClientSecret)
+	}
+}
+
+func TestReadConfigFileIncludeCycleDetected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-loader-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeConfigFixture(t, dir, "a.yaml", "includes:\n  - b.yaml\n")
+	path := writeConfigFixture(t, dir, "b.yaml", "includes:\n  - a.yaml\n")
+
+	if err := readConfigFile(path, &Config{}); err == nil {
+		t.Error("expected an include cycle to be detected")
+	}
+}
+
+// TestReadConfigFileDiamondIncludeIsNotACycle guards the fix for a false-positive
+// cycle: two distinct fragments both including a shared third fragment (the "split
+// large configs into per-resource fragments" use case) must load successfully
+func TestReadConfigFileDiamondIncludeIsNotACycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-loader-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeConfigFixture(t, dir, "shared.yaml", "client-secret: shared-secret\n")
+	writeConfigFixture(t, dir, "fragment-a.yaml", "includes:\n  - shared.yaml\nclient-id: from-a\n")
+	writeConfigFixture(t, dir, "fragment-b.yaml", "includes:\n  - shared.yaml\nredirection-url: from-b\n")
+	path := writeConfigFixture(t, dir, "config.yaml", `
+includes:
+  - fragment-a.yaml
+  - fragment-b.yaml
+encryption-key: "0123456789012345"
+`)
+
+	cfg := &Config{}
+	if err := readConfigFile(path, cfg); err != nil {
+		t.Fatalf("expected a diamond include to load successfully, got error: %s", err)
+	}
+	if cfg.ClientSecret != "shared-secret" {
+		t.Errorf("expected the shared fragment to be merged in, got %q", cfg.ClientSecret)
+	}
+	if cfg.ClientID != "from-a" || cfg.RedirectionURL != "from-b" {
+		t.Errorf("expected both sibling fragments to be merged, got client-id=%q redirection-url=%q", cfg.ClientID, cfg.RedirectionURL)
+	}
+}
+
+// TestReadConfigFileIncludesMergeLists guards the fix for deepMergeMaps overwriting
+// list-valued keys wholesale: two sibling fragments each contributing their own
+// `resources` entry must both end up in the merged config, not just the last one
+// loaded, since splitting a large config into per-resource fragments depends on it
+func TestReadConfigFileIncludesMergeLists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-loader-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeConfigFixture(t, dir, "resources-a.yaml", `
+resources:
+  - url: /admin
+    roles: [admin]
+`)
+	writeConfigFixture(t, dir, "resources-b.yaml", `
+resources:
+  - url: /public
+    white-listed: true
+`)
+	path := writeConfigFixture(t, dir, "config.yaml", `
+includes:
+  - resources-a.yaml
+  - resources-b.yaml
+encryption-key: "0123456789012345"
+`)
+
+	cfg := &Config{}
+	if err := readConfigFile(path, cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(cfg.Resources) != 2 {
+		t.Fatalf("expected both fragments' resources to be merged, got %d: %v", len(cfg.Resources), cfg.Resources)
+	}
+}
+
+func TestReadConfigFileRejectsUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config-loader-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeConfigFixture(t, dir, "config.yaml", `
+client-id: my-client
+this-key-does-not-exist: true
+encryption-key: "0123456789012345"
+`)
+
+	if err := readConfigFile(path, &Config{}); err == nil {
+		t.Error("expected an unknown configuration key to be rejected")
+	}
+}
+
+func TestValidateConfigAggregatesErrors(t *testing.T) {
+	cfg := &Config{
+		TLSCertificate: "/does/not/exist.pem",
+		LocalAuthPaths: []string{"/healthz"},
+		Resources: []*Resource{
+			{URL: "/api", RolesAllowed: []string{"admin"}},
+			{URL: "/api"},
+		},
+		Roles: []string{"viewer"},
+	}
+
+	err := validateConfig(cfg)
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	configErr, ok := err.(*configError)
+	if !ok {
+		t.Fatalf("expected a *configError, got %T", err)
+	}
+	if len(configErr.problems) < 5 {
+		t.Errorf("expected at least 5 aggregated problems (tls, local-auth file, duplicate url, unknown role, missing encryption key), got %d: %v", len(configErr.problems), configErr.problems)
+	}
+}
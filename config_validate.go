@@ -0,0 +1,144 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// configError aggregates every problem found while validating a configuration so
+// an operator sees the full list of mistakes in one pass rather than fixing them
+// one startup attempt at a time
+type configError struct {
+	problems []string
+}
+
+// Error implements the error interface
+func (e *configError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.problems, "\n  - "))
+}
+
+// add records a problem found during validation
+func (e *configError) add(format string, args ...interface{}) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+// validateConfig cross-checks the fully resolved configuration, returning a single
+// aggregated error describing every problem found, or nil if the configuration is valid
+func validateConfig(cfg *Config) error {
+	errs := &configError{}
+
+	validateLocalAuth(cfg, errs)
+	validateTLS(cfg, errs)
+	validateLetsEncrypt(cfg, errs)
+	validateUpstreamTLS(cfg, errs)
+	validateEncryption(cfg, errs)
+	validateResources(cfg, errs)
+
+	if len(errs.problems) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateLocalAuth(cfg *Config, errs *configError) {
+	seen := make(map[string]bool)
+	for _, path := range cfg.LocalAuthPaths {
+		if seen[path] {
+			errs.add("local-auth-paths contains a duplicate entry: %s", path)
+		}
+		seen[path] = true
+	}
+	if len(cfg.LocalAuthPaths) > 0 && cfg.LocalAuthFile == "" {
+		errs.add("local-auth-paths is set but local-auth-file is empty")
+	}
+	for _, resource := range cfg.Resources {
+		if resource.LocalAuth && cfg.LocalAuthFile == "" {
+			errs.add("resource %s sets local-auth but local-auth-file is empty", resource.URL)
+		}
+	}
+	if cfg.LocalAuthFile != "" && !fileExists(cfg.LocalAuthFile) {
+		errs.add("local-auth-file does not exist: %s", cfg.LocalAuthFile)
+	}
+}
+
+func validateTLS(cfg *Config, errs *configError) {
+	if cfg.TLSCertificate != "" && !fileExists(cfg.TLSCertificate) {
+		errs.add("tls-cert does not exist: %s", cfg.TLSCertificate)
+	}
+	if cfg.TLSPrivateKey != "" && !fileExists(cfg.TLSPrivateKey) {
+		errs.add("tls-private-key does not exist: %s", cfg.TLSPrivateKey)
+	}
+}
+
+func validateLetsEncrypt(cfg *Config, errs *configError) {
+	if cfg.EnableLetsEncrypt && len(cfg.LetsEncryptHosts) == 0 {
+		errs.add("enable-letsencrypt is set but no letsencrypt-hosts have been whitelisted")
+	}
+}
+
+func validateUpstreamTLS(cfg *Config, errs *configError) {
+	if cfg.UpstreamCACertificate != "" && !fileExists(cfg.UpstreamCACertificate) {
+		errs.add("upstream-ca-certificate does not exist: %s", cfg.UpstreamCACertificate)
+	}
+	if (cfg.UpstreamClientCertificate != "") != (cfg.UpstreamClientKey != "") {
+		errs.add("upstream-client-certificate and upstream-client-key must be set together")
+	}
+	if cfg.UpstreamMinTLSVersion != "" {
+		if _, found := tlsVersions[cfg.UpstreamMinTLSVersion]; !found {
+			errs.add("upstream-min-tls-version is not a recognised tls version: %s", cfg.UpstreamMinTLSVersion)
+		}
+	}
+}
+
+func validateEncryption(cfg *Config, errs *configError) {
+	keys := encryptionKeysFromConfig(cfg)
+	if len(keys) == 0 || keys[0] == "" {
+		errs.add("no encryption-key or encryption-keys have been configured")
+	}
+}
+
+// validateResources cross-checks that every resource's URL is unique -- two resources
+// matching the same path is almost always a copy-paste mistake that silently shadows
+// one of them -- and, when the operator has declared the realm's full set of roles via
+// Roles, that every role a resource references actually exists. Role cross-checking is
+// skipped entirely when Roles is empty so deployments which don't want to maintain that
+// list aren't forced to
+func validateResources(cfg *Config, errs *configError) {
+	knownRoles := make(map[string]bool, len(cfg.Roles))
+	for _, role := range cfg.Roles {
+		knownRoles[role] = true
+	}
+
+	seenURLs := make(map[string]bool, len(cfg.Resources))
+	for _, resource := range cfg.Resources {
+		if seenURLs[resource.URL] {
+			errs.add("resources contains a duplicate url: %s", resource.URL)
+		}
+		seenURLs[resource.URL] = true
+
+		if len(cfg.Roles) == 0 {
+			continue
+		}
+		for _, role := range resource.RolesAllowed {
+			if !knownRoles[role] {
+				errs.add("resource %s references unknown role: %s", resource.URL, role)
+			}
+		}
+	}
+}
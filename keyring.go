@@ -0,0 +1,174 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxEncryptionKeys is the number of keys a keyring can reasonably hold; it's not
+// enforced by the id space (see keyIDSize) but guards against runaway config
+const maxEncryptionKeys = 256
+
+// keyIDSize is the number of leading hash bytes used to identify a key. It's wide
+// enough that a collision between two distinct, independently chosen keys is not
+// something operators need to plan for in practice, which matters because the id
+// is derived purely from the key's own content: there is deliberately no
+// position-dependent fallback that could make a key's id depend on where it sits
+// in the configured list
+const keyIDSize = 8
+
+// keyID is the stable identifier prefixed onto ciphertext so the key used to seal
+// it can be found again regardless of how the configured key list has been reordered
+type keyID [keyIDSize]byte
+
+// deriveKeyID derives a stable identifier for a key from its own content rather
+// than its position in the configured list, so promoting a secondary key to primary
+// (which reorders the list) never changes the id that key's existing cookies were
+// sealed under. It's a package variable, rather than a plain function, purely so
+// tests can substitute a colliding implementation to exercise reload's collision
+// handling without needing to find two real keys that collide on keyIDSize bytes
+var deriveKeyID = func(key []byte) keyID {
+	sum := sha256.Sum256(key)
+
+	var id keyID
+	copy(id[:], sum[:keyIDSize])
+
+	return id
+}
+
+// encryptionKeyring is an ordered, rotatable set of session encryption keys. The
+// first key supplied is always the active key used to encrypt new session cookies;
+// the remainder are retained purely so cookies sealed under a previous active key
+// continue to decrypt until they expire
+type encryptionKeyring struct {
+	sync.RWMutex
+	// order is the list of key ids, index zero being the active key
+	order []keyID
+	// keys maps a key id (the bytes prefixed onto ciphertext) to its raw key material
+	keys map[keyID][]byte
+}
+
+// newEncryptionKeyring creates a keyring from an ordered list of raw keys, the first
+// of which becomes the active, encrypt-capable key
+func newEncryptionKeyring(keys []string) (*encryptionKeyring, error) {
+	kr := &encryptionKeyring{}
+	if err := kr.reload(keys); err != nil {
+		return nil, err
+	}
+
+	return kr, nil
+}
+
+// reload atomically replaces the contents of the keyring, used both on initial
+// construction and whenever the configuration is reloaded at runtime. Because a
+// key's id is derived solely from its own content, two distinct keys colliding on
+// their derived id can't be resolved without making the id depend on list order
+// again -- so reload fails closed and asks the operator to pick different key
+// material rather than silently reintroducing that dependency
+func (r *encryptionKeyring) reload(keys []string) error {
+	if len(keys) <= 0 {
+		return errors.New("no encryption keys have been configured")
+	}
+	if len(keys) > maxEncryptionKeys {
+		return errors.New("too many encryption keys configured, 256 is the maximum supported")
+	}
+
+	order := make([]keyID, len(keys))
+	table := make(map[keyID][]byte, len(keys))
+	for i, key := range keys {
+		raw := []byte(key)
+		id := deriveKeyID(raw)
+
+		if existing, taken := table[id]; taken && string(existing) != string(raw) {
+			return fmt.Errorf("two configured encryption keys collide on their derived id, please use different key material")
+		}
+		order[i] = id
+		table[id] = raw
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.order = order
+	r.keys = table
+
+	return nil
+}
+
+// activeKey returns the id and key material currently used to encrypt new session cookies
+func (r *encryptionKeyring) activeKey() (keyID, []byte) {
+	r.RLock()
+	defer r.RUnlock()
+
+	id := r.order[0]
+
+	return id, r.keys[id]
+}
+
+// keyFor looks up the key material for a given key id, returning false if the id
+// is unknown, for example because the key has since been dropped from the config
+func (r *encryptionKeyring) keyFor(id keyID) ([]byte, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	key, found := r.keys[id]
+
+	return key, found
+}
+
+// encryptionKeysFromConfig resolves the configured keyring, with EncryptionKey acting
+// as sugar for a single-element EncryptionKeys list when the latter isn't set
+func encryptionKeysFromConfig(cfg *Config) []string {
+	if len(cfg.EncryptionKeys) > 0 {
+		return cfg.EncryptionKeys
+	}
+
+	return []string{cfg.EncryptionKey}
+}
+
+// watchEncryptionKeyringReload reloads the keyring from the config file whenever the
+// process receives a SIGHUP, so operators can rotate keys without dropping in-flight
+// sessions: add the new key as secondary, promote it to primary, then drop the old key
+// once it has aged out of every outstanding session's TTL
+func watchEncryptionKeyringReload(cfg *Config, keyring *encryptionKeyring) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	go func() {
+		for range sigCh {
+			log.Info("sighup received, reloading the encryption keyring")
+
+			reloaded := &Config{}
+			if err := readConfigFile(cfg.ConfigFile, reloaded); err != nil {
+				log.Errorf("failed to reload config file for keyring rotation, error: %s", err)
+				continue
+			}
+			if err := keyring.reload(encryptionKeysFromConfig(reloaded)); err != nil {
+				log.Errorf("failed to reload encryption keyring, error: %s", err)
+				continue
+			}
+			log.Info("encryption keyring reloaded successfully")
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestEncodeDecodeTextRoundTrip(t *testing.T) {
+	keyring, err := newEncryptionKeyring([]string{"0123456789012345"})
+	if err != nil {
+		t.Fatalf("unexpected error building keyring: %s", err)
+	}
+
+	cases := []string{"", "hello world", "1469803934|a-jwt-access-token"}
+	for _, plaintext := range cases {
+		encoded, err := encodeText(plaintext, keyring)
+		if err != nil {
+			t.Fatalf("unexpected error encoding %q: %s", plaintext, err)
+		}
+		decoded, err := decodeText(encoded, keyring)
+		if err != nil {
+			t.Fatalf("unexpected error decoding %q: %s", plaintext, err)
+		}
+		if decoded != plaintext {
+			t.Errorf("expected %q, got %q", plaintext, decoded)
+		}
+	}
+}
+
+// TestKeyringSurvivesKeyRotation proves the exact rotation workflow the feature was
+// built for: add a new key as secondary, promote it to primary, then drop the old
+// key -- and a cookie sealed before the rotation started must still decrypt at every
+// step until the key it was sealed under is actually removed
+func TestKeyringSurvivesKeyRotation(t *testing.T) {
+	oldKey := "aaaaaaaaaaaaaaaa"
+	newKey := "bbbbbbbbbbbbbbbb"
+
+	keyring, err := newEncryptionKeyring([]string{oldKey})
+	if err != nil {
+		t.Fatalf("unexpected error building keyring: %s", err)
+	}
+
+	cookie, err := encodeText("a-session-token", keyring)
+	if err != nil {
+		t.Fatalf("unexpected error encoding: %s", err)
+	}
+
+	// step: add the new key as a secondary, existing cookie must still decrypt
+	if err := keyring.reload([]string{oldKey, newKey}); err != nil {
+		t.Fatalf("unexpected error adding secondary key: %s", err)
+	}
+	if _, err := decodeText(cookie, keyring); err != nil {
+		t.Fatalf("cookie failed to decrypt after adding a secondary key: %s", err)
+	}
+
+	// step: promote the new key to primary, the old cookie (sealed under the old
+	// key's stable id) must still decrypt -- this is the scenario that broke when
+	// ids were assigned by list position rather than derived from the key itself
+	if err := keyring.reload([]string{newKey, oldKey}); err != nil {
+		t.Fatalf("unexpected error promoting secondary key: %s", err)
+	}
+	decoded, err := decodeText(cookie, keyring)
+	if err != nil {
+		t.Fatalf("cookie failed to decrypt after promoting the new primary key: %s", err)
+	}
+	if decoded != "a-session-token" {
+		t.Errorf("expected %q, got %q", "a-session-token", decoded)
+	}
+
+	// step: a cookie encrypted after promotion must still decrypt correctly; that it
+	// was sealed under the new primary key's id is shown below, once the old key is dropped
+	postRotationCookie, err := encodeText("another-session-token", keyring)
+	if err != nil {
+		t.Fatalf("unexpected error encoding post-rotation: %s", err)
+	}
+	if decoded, err := decodeText(postRotationCookie, keyring); err != nil || decoded != "another-session-token" {
+		t.Fatalf("post-rotation cookie round-trip failed: decoded=%q, err=%s", decoded, err)
+	}
+
+	// step: finally, drop the old key once it has aged out -- the original cookie
+	// must now fail to decrypt, but the post-rotation cookie must be unaffected
+	if err := keyring.reload([]string{newKey}); err != nil {
+		t.Fatalf("unexpected error dropping the old key: %s", err)
+	}
+	if _, err := decodeText(cookie, keyring); err == nil {
+		t.Error("expected the original cookie to fail decryption once its key was dropped")
+	}
+	if decoded, err := decodeText(postRotationCookie, keyring); err != nil || decoded != "another-session-token" {
+		t.Fatalf("post-rotation cookie should still decrypt after the old key is dropped: decoded=%q, err=%s", decoded, err)
+	}
+}
+
+func TestKeyIDStableAcrossReorder(t *testing.T) {
+	a, b := "aaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbb"
+
+	forward, err := newEncryptionKeyring([]string{a, b})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	reversed, err := newEncryptionKeyring([]string{b, a})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	idInForward, found := lookupID(forward, []byte(a))
+	if !found {
+		t.Fatalf("expected to find key a in the forward keyring")
+	}
+	idInReversed, found := lookupID(reversed, []byte(a))
+	if !found {
+		t.Fatalf("expected to find key a in the reversed keyring")
+	}
+	if idInForward != idInReversed {
+		t.Errorf("expected key %q to keep the same id regardless of list position, got %x and %x", a, idInForward, idInReversed)
+	}
+}
+
+// TestKeyringRejectsCollidingKeysRatherThanOrderResolving proves that a derived-id
+// collision between two distinct keys is rejected outright rather than resolved by
+// list position (e.g. linear probing), since a position-dependent resolution would
+// reintroduce exactly the rotation bug this keyring design exists to avoid
+func TestKeyringRejectsCollidingKeysRatherThanOrderResolving(t *testing.T) {
+	original := deriveKeyID
+	defer func() { deriveKeyID = original }()
+
+	// step: force every key to collide on the same id, as if two real keys had
+	// happened to share their leading keyIDSize hash bytes
+	deriveKeyID = func(key []byte) keyID {
+		return keyID{0xAA}
+	}
+
+	_, err := newEncryptionKeyring([]string{"aaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbb"})
+	if err == nil {
+		t.Fatal("expected a derived-id collision between two distinct keys to be rejected")
+	}
+}
+
+// lookupID is a small test helper that finds the id a given key was stored under
+func lookupID(r *encryptionKeyring, key []byte) (keyID, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for id, stored := range r.keys {
+		if string(stored) == string(key) {
+			return id, true
+		}
+	}
+
+	return keyID{}, false
+}
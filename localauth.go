@@ -0,0 +1,192 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/tg123/go-htpasswd"
+)
+
+// localAuthReloadGuard is the minimum interval allowed between htpasswd reloads, in
+// order to protect against a SIGHUP storm hammering the filesystem
+const localAuthReloadGuard = 5 * time.Second
+
+// errReloadCoalesced is returned by reload when a request arrives inside the guard
+// window. It is not a failure: the reload has been scheduled to run as soon as the
+// window elapses, rather than being dropped, so a caller must not report it as an
+// error that leaves the file un-reloaded
+var errReloadCoalesced = errors.New("local auth reload coalesced, will be applied once the cooldown window elapses")
+
+// localAuthenticator validates HTTP Basic credentials against an htpasswd file, used
+// as a break-glass authentication backend for routes which can't perform the full
+// OIDC redirect flow, such as probes and machine-to-machine callers
+type localAuthenticator struct {
+	sync.RWMutex
+	// file is the path to the htpasswd file backing this authenticator
+	file string
+	// roles are granted to the identity synthesized on a successful local login
+	roles []string
+	// auth is the currently loaded htpasswd credential set
+	auth *htpasswd.File
+	// lastReload guards against reloading the file more often than localAuthReloadGuard
+	lastReload time.Time
+	// pendingReload is set while a coalesced reload is scheduled to run once the
+	// guard window elapses, so a burst of requests schedules at most one retry
+	pendingReload bool
+}
+
+// newLocalAuthenticator loads the htpasswd file referenced by the configuration and
+// wraps it behind the rw-mutex the reload path uses
+func newLocalAuthenticator(cfg *Config) (*localAuthenticator, error) {
+	auth, err := htpasswd.New(cfg.LocalAuthFile, htpasswd.DefaultSystems, logHtpasswdParseError)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localAuthenticator{
+		file:  cfg.LocalAuthFile,
+		roles: cfg.LocalAuthRoles,
+		auth:  auth,
+	}, nil
+}
+
+// reload re-reads the htpasswd file from disk, swapping it in atomically so in-flight
+// requests are never served a half-parsed credential set. A reload requested inside
+// the guard window is never silently dropped: it is coalesced into a single deferred
+// retry that fires once the window elapses, and errReloadCoalesced is returned so the
+// caller knows the change hasn't been applied yet rather than mistaking it for success
+func (l *localAuthenticator) reload() error {
+	l.Lock()
+
+	if wait := localAuthReloadGuard - time.Since(l.lastReload); wait > 0 {
+		if !l.pendingReload {
+			l.pendingReload = true
+			time.AfterFunc(wait, func() {
+				if err := l.reload(); err != nil && err != errReloadCoalesced {
+					log.Errorf("failed to apply coalesced local auth reload, error: %s", err)
+				}
+			})
+		}
+		l.Unlock()
+
+		return errReloadCoalesced
+	}
+	l.pendingReload = false
+	l.lastReload = time.Now()
+	l.Unlock()
+
+	auth, err := htpasswd.New(l.file, htpasswd.DefaultSystems, logHtpasswdParseError)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	l.auth = auth
+	l.Unlock()
+
+	return nil
+}
+
+// authenticate validates the supplied basic-auth credentials against the htpasswd file,
+// returning the roles to grant the synthesized identity on success
+func (l *localAuthenticator) authenticate(username, password string) ([]string, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	if !l.auth.Match(username, password) {
+		return nil, false
+	}
+
+	return l.roles, true
+}
+
+// logHtpasswdParseError is passed to htpasswd.New so a malformed line is logged rather
+// than aborting the load of the rest of the file
+func logHtpasswdParseError(err error) {
+	log.Warnf("error parsing htpasswd entry: %s", err)
+}
+
+// isLocalAuthPath checks whether the request path is permitted to use the local-auth
+// fallback in place of a Keycloak session, either via the coarse-grained
+// LocalAuthPaths prefix list or a resource's own LocalAuth flag
+func isLocalAuthPath(cfg *Config, path string) bool {
+	for _, prefix := range cfg.LocalAuthPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	for _, resource := range cfg.Resources {
+		if resource.LocalAuth && resource.URL == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireLocalBasicAuth is consulted before the OIDC flow for any request matching
+// isLocalAuthPath. Precedence: an Authorization: Basic header always takes priority
+// over an existing session cookie, so operators can force a local login on a route
+// even for a browser that already holds a valid Keycloak session
+func requireLocalBasicAuth(l *localAuthenticator, realm string, w http.ResponseWriter, req *http.Request) ([]string, bool) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+
+	roles, authenticated := l.authenticate(username, password)
+	if !authenticated {
+		log.Warnf("local auth login failed, resource: %s, username: %s", req.URL.Path, username)
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, defaultTo(realm, "Restricted")))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+		return nil, false
+	}
+	log.Infof("local auth login succeeded, resource: %s, username: %s", req.URL.Path, username)
+
+	return roles, true
+}
+
+// watchLocalAuthReload reloads the htpasswd file whenever the process receives a
+// SIGHUP, so operators can add or revoke break-glass credentials without restarting
+func watchLocalAuthReload(l *localAuthenticator) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	go func() {
+		for range sigCh {
+			log.Info("sighup received, reloading the local auth htpasswd file")
+			switch err := l.reload(); err {
+			case nil:
+				log.Info("local auth htpasswd file reloaded successfully")
+			case errReloadCoalesced:
+				log.Info("local auth reload requested too soon after the last one, it has been scheduled to run shortly")
+			default:
+				log.Errorf("failed to reload local auth htpasswd file, error: %s", err)
+			}
+		}
+	}()
+}
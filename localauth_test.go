@@ -0,0 +1,181 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeHtpasswdFile builds a minimal bcrypt htpasswd file for a single user
+func writeHtpasswdFile(t *testing.T, dir, username, password string) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("unable to generate bcrypt hash: %s", err)
+	}
+
+	path := filepath.Join(dir, "htpasswd")
+	content := fmt.Sprintf("%s:%s\n", username, hash)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unable to write htpasswd file: %s", err)
+	}
+
+	return path
+}
+
+func TestLocalAuthenticatorBcrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-auth-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeHtpasswdFile(t, dir, "probe", "s3cret")
+	cfg := &Config{LocalAuthFile: path, LocalAuthRoles: []string{"probe"}}
+
+	authenticator, err := newLocalAuthenticator(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if roles, ok := authenticator.authenticate("probe", "s3cret"); !ok || len(roles) != 1 || roles[0] != "probe" {
+		t.Errorf("expected successful authentication with the probe role, got roles=%v ok=%v", roles, ok)
+	}
+	if _, ok := authenticator.authenticate("probe", "wrong-password"); ok {
+		t.Error("expected authentication to fail with an incorrect password")
+	}
+	if _, ok := authenticator.authenticate("nobody", "s3cret"); ok {
+		t.Error("expected authentication to fail for an unknown user")
+	}
+}
+
+func TestLocalAuthenticatorReloadCoalescesWithinGuard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-auth-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeHtpasswdFile(t, dir, "probe", "s3cret")
+	cfg := &Config{LocalAuthFile: path}
+
+	authenticator, err := newLocalAuthenticator(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// step: the very first reload is outside the guard window (lastReload is the
+	// zero value) and must apply immediately
+	if err := authenticator.reload(); err != nil {
+		t.Fatalf("unexpected error on first reload: %s", err)
+	}
+
+	// step: a reload requested immediately afterwards falls inside the guard window;
+	// it must be reported distinctly as coalesced rather than silently dropped as
+	// a successful no-op, and the scheduled retry must be marked pending
+	err = authenticator.reload()
+	if err != errReloadCoalesced {
+		t.Fatalf("expected errReloadCoalesced, got %v", err)
+	}
+
+	authenticator.RLock()
+	pending := authenticator.pendingReload
+	authenticator.RUnlock()
+	if !pending {
+		t.Error("expected the coalesced reload to be marked pending so it still runs once the guard window elapses")
+	}
+}
+
+func TestRequireLocalBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-auth-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeHtpasswdFile(t, dir, "probe", "s3cret")
+	cfg := &Config{LocalAuthFile: path, LocalAuthRoles: []string{"probe"}}
+	authenticator, err := newLocalAuthenticator(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	t.Run("no authorization header falls through to the oidc flow", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/secure", nil)
+		recorder := httptest.NewRecorder()
+
+		_, handled := requireLocalBasicAuth(authenticator, "", recorder, req)
+		if handled {
+			t.Error("expected requireLocalBasicAuth to decline handling a request with no basic auth header")
+		}
+	})
+
+	t.Run("valid credentials succeed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/secure", nil)
+		req.SetBasicAuth("probe", "s3cret")
+		recorder := httptest.NewRecorder()
+
+		roles, handled := requireLocalBasicAuth(authenticator, "", recorder, req)
+		if !handled {
+			t.Fatal("expected requireLocalBasicAuth to succeed with valid credentials")
+		}
+		if len(roles) != 1 || roles[0] != "probe" {
+			t.Errorf("expected the configured roles to be returned, got %v", roles)
+		}
+	})
+
+	t.Run("invalid credentials return 401 with a WWW-Authenticate challenge", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/secure", nil)
+		req.SetBasicAuth("probe", "wrong-password")
+		recorder := httptest.NewRecorder()
+
+		_, handled := requireLocalBasicAuth(authenticator, "my-realm", recorder, req)
+		if handled {
+			t.Fatal("expected requireLocalBasicAuth to reject invalid credentials")
+		}
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("expected a 401 response, got %d", recorder.Code)
+		}
+		if challenge := recorder.Header().Get("WWW-Authenticate"); challenge != `Basic realm="my-realm"` {
+			t.Errorf("unexpected WWW-Authenticate header: %s", challenge)
+		}
+	})
+}
+
+func TestIsLocalAuthPath(t *testing.T) {
+	cfg := &Config{LocalAuthPaths: []string{"/healthz", "/metrics"}}
+
+	cases := map[string]bool{
+		"/healthz":      true,
+		"/healthz/live": true,
+		"/metrics":      true,
+		"/secure":       false,
+	}
+	for path, expected := range cases {
+		if got := isLocalAuthPath(cfg, path); got != expected {
+			t.Errorf("path %q: expected %v, got %v", path, expected, got)
+		}
+	}
+}
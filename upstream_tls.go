@@ -0,0 +1,77 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// tlsVersions maps the human readable config values onto the crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"tlsv1.0": tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+// newUpstreamTLSConfig builds the shared tls configuration used whenever gatekeeper
+// talks to the upstream endpoint, whether over the reverse-proxy transport or the
+// hijacked websocket / spdy dial path
+func newUpstreamTLSConfig(cfg *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.UpstreamSkipVerify,
+		ServerName:         cfg.UpstreamTLSServerName,
+	}
+
+	if cfg.UpstreamMinTLSVersion != "" {
+		version, found := tlsVersions[cfg.UpstreamMinTLSVersion]
+		if !found {
+			return nil, fmt.Errorf("unsupported upstream-min-tls-version: %s", cfg.UpstreamMinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if cfg.UpstreamCACertificate != "" {
+		pool, err := loadCertificateAuthorityPool(cfg.UpstreamCACertificate)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.UpstreamClientCertificate != "" {
+		if cfg.UpstreamClientKey == "" {
+			return nil, fmt.Errorf("upstream-client-certificate specified without a upstream-client-key")
+		}
+		cert, err := loadCA(cfg.UpstreamClientCertificate, cfg.UpstreamClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// newUpstreamReverseProxyTransport builds the http.Transport used by the reverse proxy
+// for ordinary (non-hijacked) requests to the upstream, honouring the shared tls config
+func newUpstreamReverseProxyTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTryDialEndpointRequiresTLSConfig guards against reintroducing the unconditional
+// skip-verify footgun: a tls endpoint dialed with no tls config supplied must fail
+// rather than silently falling back to an insecure default
+func TestTryDialEndpointRequiresTLSConfig(t *testing.T) {
+	endpoint, err := url.Parse("https://127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("unexpected error parsing url: %s", err)
+	}
+
+	if _, err := tryDialEndpoint(endpoint, nil); err == nil {
+		t.Error("expected tryDialEndpoint to fail closed when no tls config is supplied")
+	}
+}
+
+// writeTempPEM writes the supplied PEM bytes to a temp file and returns its path
+func writeTempPEM(t *testing.T, dir, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("unable to write %s: %s", path, err)
+	}
+
+	return path
+}
+
+// selfSignedCert generates a throwaway self-signed certificate, valid for localhost
+// and the given common name, along with its private key, both PEM encoded
+func selfSignedCert(t *testing.T, commonName string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(87600 * time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+		DNSNames:     []string{"localhost", commonName},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM
+}
+
+func TestNewUpstreamTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upstream-tls-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caPath := writeTempPEM(t, dir, "upstream-ca.pem", caPEM)
+
+	cases := []struct {
+		name      string
+		cfg       Config
+		expectErr bool
+	}{
+		{
+			name:      "trusted ca bundle succeeds",
+			cfg:       Config{UpstreamCACertificate: caPath, UpstreamTLSServerName: "example.com"},
+			expectErr: false,
+		},
+		{
+			name:      "no ca bundle and verification enabled fails",
+			cfg:       Config{UpstreamTLSServerName: "example.com"},
+			expectErr: true,
+		},
+		{
+			name:      "skip verify succeeds without a ca bundle",
+			cfg:       Config{UpstreamSkipVerify: true},
+			expectErr: false,
+		},
+		{
+			name:      "server name mismatch fails even with a trusted ca",
+			cfg:       Config{UpstreamCACertificate: caPath, UpstreamTLSServerName: "not-the-right-name"},
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tlsConfig, err := newUpstreamTLSConfig(&c.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error building tls config: %s", err)
+			}
+
+			client := &http.Client{
+				Transport: newUpstreamReverseProxyTransport(tlsConfig),
+				Timeout:   5 * time.Second,
+			}
+			_, err = client.Get(server.URL)
+			if c.expectErr && err == nil {
+				t.Errorf("expected an error dialing the upstream, got none")
+			}
+			if !c.expectErr && err != nil {
+				t.Errorf("unexpected error dialing the upstream: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewUpstreamTLSConfigMinVersion(t *testing.T) {
+	cases := []struct {
+		version   string
+		expectErr bool
+	}{
+		{"tlsv1.0", false},
+		{"tlsv1.1", false},
+		{"tlsv1.2", false},
+		{"tlsv1.3", false},
+		{"sslv3", true},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		cfg := &Config{UpstreamMinTLSVersion: c.version}
+		_, err := newUpstreamTLSConfig(cfg)
+		if c.expectErr && err == nil {
+			t.Errorf("version %q: expected an error, got none", c.version)
+		}
+		if !c.expectErr && err != nil {
+			t.Errorf("version %q: unexpected error: %s", c.version, err)
+		}
+	}
+}
+
+func TestNewUpstreamTLSConfigMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upstream-mtls-test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCertPEM, clientKeyPEM := selfSignedCert(t, "gatekeeper-client")
+	clientCertPath := writeTempPEM(t, dir, "client.pem", clientCertPEM)
+	clientKeyPath := writeTempPEM(t, dir, "client-key.pem", clientKeyPEM)
+
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatalf("unable to parse generated client keypair: %s", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(mustParseCert(t, clientCertPEM))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	serverCAPath := writeTempPEM(t, dir, "server-ca.pem", serverCAPEM)
+
+	cfg := &Config{
+		UpstreamCACertificate:     serverCAPath,
+		UpstreamTLSServerName:     "example.com",
+		UpstreamClientCertificate: clientCertPath,
+		UpstreamClientKey:         clientKeyPath,
+	}
+	tlsConfig, err := newUpstreamTLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error building tls config: %s", err)
+	}
+	if len(tlsConfig.Certificates) != 1 {
+		t.Fatalf("expected exactly one client certificate to be configured")
+	}
+
+	client := &http.Client{
+		Transport: newUpstreamReverseProxyTransport(tlsConfig),
+		Timeout:   5 * time.Second,
+	}
+	if _, err := client.Get(server.URL); err != nil {
+		t.Errorf("unexpected error presenting the client certificate: %s", err)
+	}
+
+	// step: without the client certificate the server must reject the handshake
+	withoutClientCert, err := newUpstreamTLSConfig(&Config{
+		UpstreamCACertificate: serverCAPath,
+		UpstreamTLSServerName: "example.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error building tls config: %s", err)
+	}
+	client = &http.Client{
+		Transport: newUpstreamReverseProxyTransport(withoutClientCert),
+		Timeout:   5 * time.Second,
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("expected the handshake to fail without a client certificate")
+	}
+
+	if clientCert.Leaf != nil && clientCert.Leaf.Subject.CommonName != "gatekeeper-client" {
+		t.Errorf("unexpected test fixture common name: %s", clientCert.Leaf.Subject.CommonName)
+	}
+}
+
+func mustParseCert(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatalf("unable to decode pem block")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unable to parse certificate: %s", err)
+	}
+
+	return cert
+}
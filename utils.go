@@ -23,7 +23,6 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -32,7 +31,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
@@ -45,7 +43,6 @@ import (
 	"github.com/coreos/go-oidc/oidc"
 	"github.com/labstack/echo"
 	"github.com/urfave/cli"
-	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -65,24 +62,6 @@ var (
 	symbolsFilter = regexp.MustCompilePOSIX("[_$><\\[\\].,\\+-/'%^&*()!\\\\]+")
 )
 
-// readConfigFile reads and parses the configuration file
-func readConfigFile(filename string, config *Config) error {
-	// step: read in the contents of the file
-	content, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return err
-	}
-	// step: attempt to un-marshal the data
-	switch ext := filepath.Ext(filename); ext {
-	case "json":
-		err = json.Unmarshal(content, config)
-	default:
-		err = yaml.Unmarshal(content, config)
-	}
-
-	return err
-}
-
 // encryptDataBlock encrypts the plaintext string with the key
 func encryptDataBlock(plaintext, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
@@ -120,24 +99,41 @@ func decryptDataBlock(cipherText, key []byte) ([]byte, error) {
 	return gcm.Open(nil, nonce, input, nil)
 }
 
-// encodeText encodes the session state information into a value for a cookie to consume
-func encodeText(plaintext string, key string) (string, error) {
-	cipherText, err := encryptDataBlock([]byte(plaintext), []byte(key))
+// encodeText encodes the session state information into a value for a cookie to consume,
+// prefixing the ciphertext with the id of the keyring's active key so it can later be
+// decrypted even after the active key has rotated
+func encodeText(plaintext string, keyring *encryptionKeyring) (string, error) {
+	id, key := keyring.activeKey()
+
+	cipherText, err := encryptDataBlock([]byte(plaintext), key)
 	if err != nil {
 		return "", err
 	}
+	cipherText = append(id[:], cipherText...)
 
 	return hex.EncodeToString(cipherText), nil
 }
 
-// decodeText decodes the session state cookie value
-func decodeText(state, key string) (string, error) {
-	cipherText, err := hex.DecodeString(state)
+// decodeText decodes the session state cookie value, looking up the key used to encrypt
+// it from the keyIDSize-byte id prefix rather than assuming the keyring's current active key
+func decodeText(state string, keyring *encryptionKeyring) (string, error) {
+	raw, err := hex.DecodeString(state)
 	if err != nil {
 		return "", err
 	}
+	if len(raw) < keyIDSize {
+		return "", ErrInvalidSession
+	}
+	var id keyID
+	copy(id[:], raw[:keyIDSize])
+	cipherText := raw[keyIDSize:]
+
+	key, found := keyring.keyFor(id)
+	if !found {
+		return "", ErrInvalidSession
+	}
 	// step: decrypt the cookie back in the expiration|token
-	encoded, err := decryptDataBlock(cipherText, []byte(key))
+	encoded, err := decryptDataBlock(cipherText, key)
 	if err != nil {
 		return "", ErrInvalidSession
 	}
@@ -286,16 +282,22 @@ func containsSubString(value string, list []string) bool {
 	return false
 }
 
-// tryDialEndpoint dials the upstream endpoint via plain
-func tryDialEndpoint(location *url.URL) (net.Conn, error) {
+// tryDialEndpoint dials the upstream endpoint via plain or tls. tlsConfig must be a
+// config built by newUpstreamTLSConfig; unlike the rest of this function's history,
+// there is deliberately no insecure fallback for a nil config, since that previously
+// reintroduced the unconditional skip-verify footgun this subsystem exists to remove
+func tryDialEndpoint(location *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
 	switch dialAddress := dialAddress(location); location.Scheme {
 	case httpSchema:
 		return net.Dial("tcp", dialAddress)
 	default:
-		return tls.Dial("tcp", dialAddress, &tls.Config{
-			Rand:               rand.Reader,
-			InsecureSkipVerify: true,
-		})
+		if tlsConfig == nil {
+			return nil, errors.New("tryDialEndpoint: no upstream tls configuration supplied for a tls endpoint")
+		}
+		conf := *tlsConfig
+		conf.Rand = rand.Reader
+
+		return tls.Dial("tcp", dialAddress, &conf)
 	}
 }
 
@@ -311,9 +313,9 @@ func transferBytes(src io.Reader, dest io.Writer, wg *sync.WaitGroup) (int64, er
 }
 
 // tryUpdateConnection attempt to upgrade the connection to a http pdy stream
-func tryUpdateConnection(req *http.Request, writer http.ResponseWriter, endpoint *url.URL) error {
+func tryUpdateConnection(req *http.Request, writer http.ResponseWriter, endpoint *url.URL, tlsConfig *tls.Config) error {
 	// step: dial the endpoint
-	tlsConn, err := tryDialEndpoint(endpoint)
+	tlsConn, err := tryDialEndpoint(endpoint, tlsConfig)
 	if err != nil {
 		return err
 	}
@@ -420,6 +422,22 @@ func loadCA(cert, key string) (*tls.Certificate, error) {
 	return &ca, err
 }
 
+// loadCertificateAuthorityPool reads a PEM bundle from disk and returns a certificate
+// pool which can be used to validate an upstream's presented certificate
+func loadCertificateAuthorityPool(bundle string) (*x509.CertPool, error) {
+	caCert, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("unable to parse any certificates from: %s", bundle)
+	}
+
+	return pool, nil
+}
+
 // getWithin calculates a duration of x percent of the time period, i.e. something
 // expires in 1 hours, get me a duration within 80%
 func getWithin(expires time.Time, within float64) time.Duration {